@@ -0,0 +1,179 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerConfig Configuration of the circuit breaker wrapping the
+// exchanges with the server
+type BreakerConfig struct {
+	// ConsecutiveFailures trips the breaker after that many failures in
+	// a row. 0 disables this condition
+	ConsecutiveFailures int
+	// FailureRate trips the breaker when the ratio of failed exchanges
+	// within Window reaches it. 0 disables this condition
+	FailureRate float64
+	// MinRequests is the number of exchanges needed within Window
+	// before FailureRate is considered
+	MinRequests int
+	Window      time.Duration
+	// OpenTimeout is the time the breaker stays open before letting a
+	// single probe exchange through
+	OpenTimeout time.Duration
+}
+
+type breakerResult struct {
+	at time.Time
+	ok bool
+}
+
+// CircuitBreaker Stops the client from hammering an overloaded server.
+// While closed every exchange is allowed. Once tripped it opens and
+// rejects exchanges until OpenTimeout elapses. Then it goes half-open
+// and allows a single probe: success closes it again, failure reopens it.
+// A nil *CircuitBreaker allows every exchange
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	clientID    string
+	config      BreakerConfig
+	state       string
+	consecutive int
+	results     []breakerResult
+	openedAt    time.Time
+	probing     bool
+}
+
+// NewCircuitBreaker Returns a closed circuit breaker
+func NewCircuitBreaker(clientID string, config BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		clientID: clientID,
+		config:   config,
+		state:    BreakerClosed,
+	}
+}
+
+// Allow Returns whether an exchange with the server may be attempted
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.transition(BreakerHalfOpen, "open timeout elapsed")
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// Only one probe at a time is let through
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record Registers the result of an exchange allowed by Allow
+func (b *CircuitBreaker) Record(ok bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.results = append(b.results, breakerResult{at: now, ok: ok})
+	b.prune(now)
+	if ok {
+		b.consecutive = 0
+	} else {
+		b.consecutive++
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		b.probing = false
+		if ok {
+			b.results = nil
+			b.transition(BreakerClosed, "probe succeeded")
+		} else {
+			b.open("probe failed")
+		}
+	case BreakerClosed:
+		if ok {
+			return
+		}
+		if b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures {
+			b.open("consecutive failures")
+		} else if rate, n := b.failureRate(); b.config.FailureRate > 0 && n >= b.config.MinRequests && rate >= b.config.FailureRate {
+			b.open("failure rate")
+		}
+	}
+}
+
+// State Returns the current state of the breaker
+func (b *CircuitBreaker) State() string {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *CircuitBreaker) open(reason string) {
+	b.openedAt = time.Now()
+	b.transition(BreakerOpen, reason)
+}
+
+func (b *CircuitBreaker) transition(state string, reason string) {
+	log.Warnf("action: circuit_breaker | result: success | client_id: %v | from: %v | to: %v | reason: %v | consecutive_failures: %v",
+		b.clientID,
+		b.state,
+		state,
+		reason,
+		b.consecutive,
+	)
+	b.state = state
+}
+
+// prune Drops the results that fell out of the window
+func (b *CircuitBreaker) prune(now time.Time) {
+	i := 0
+	for i < len(b.results) && now.Sub(b.results[i].at) > b.config.Window {
+		i++
+	}
+	b.results = b.results[i:]
+}
+
+// failureRate Returns the ratio of failed exchanges within the window
+// and the number of exchanges it was computed from
+func (b *CircuitBreaker) failureRate() (float64, int) {
+	if len(b.results) == 0 {
+		return 0, 0
+	}
+	failures := 0
+	for _, result := range b.results {
+		if !result.ok {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(b.results)), len(b.results)
+}
@@ -0,0 +1,187 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(config BreakerConfig) *CircuitBreaker {
+	if config.Window == 0 {
+		config.Window = time.Minute
+	}
+	if config.OpenTimeout == 0 {
+		config.OpenTimeout = time.Minute
+	}
+	return NewCircuitBreaker("1", config)
+}
+
+func recordAll(breaker *CircuitBreaker, results ...bool) {
+	for _, ok := range results {
+		breaker.Allow()
+		breaker.Record(ok)
+	}
+}
+
+func assertState(t *testing.T, breaker *CircuitBreaker, want string) {
+	t.Helper()
+	if got := breaker.State(); got != want {
+		t.Fatalf("state = %v, want %v", got, want)
+	}
+}
+
+func TestBreakerTripsOnConsecutiveFailures(t *testing.T) {
+	breaker := newTestBreaker(BreakerConfig{ConsecutiveFailures: 3})
+
+	recordAll(breaker, false, false)
+	assertState(t, breaker, BreakerClosed)
+
+	recordAll(breaker, false)
+	assertState(t, breaker, BreakerOpen)
+	if breaker.Allow() {
+		t.Fatal("open breaker allowed an exchange")
+	}
+}
+
+func TestBreakerSuccessResetsConsecutiveFailures(t *testing.T) {
+	breaker := newTestBreaker(BreakerConfig{ConsecutiveFailures: 3})
+
+	recordAll(breaker, false, false, true, false, false)
+	assertState(t, breaker, BreakerClosed)
+}
+
+func TestBreakerTripsOnFailureRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []bool
+		want    string
+	}{
+		{"below min requests", []bool{false, true, false}, BreakerClosed},
+		{"rate reached", []bool{false, true, false, false}, BreakerOpen},
+		{"rate not reached", []bool{true, true, true, false}, BreakerClosed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := newTestBreaker(BreakerConfig{FailureRate: 0.5, MinRequests: 4})
+			recordAll(breaker, test.results...)
+			assertState(t, breaker, test.want)
+		})
+	}
+}
+
+func TestBreakerPrunesResultsOutsideWindow(t *testing.T) {
+	breaker := newTestBreaker(BreakerConfig{FailureRate: 0.5, MinRequests: 4, Window: 30 * time.Millisecond})
+
+	recordAll(breaker, false, false, false)
+	time.Sleep(40 * time.Millisecond)
+
+	// Only the newest failure is within the window, below MinRequests
+	recordAll(breaker, false)
+	assertState(t, breaker, BreakerClosed)
+	if _, n := breaker.failureRate(); n != 1 {
+		t.Fatalf("results within window = %v, want 1", n)
+	}
+}
+
+func TestBreakerHalfOpenLetsSingleProbeThrough(t *testing.T) {
+	breaker := newTestBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: 20 * time.Millisecond})
+	recordAll(breaker, false)
+	assertState(t, breaker, BreakerOpen)
+
+	time.Sleep(30 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("breaker did not allow a probe after the open timeout")
+	}
+	assertState(t, breaker, BreakerHalfOpen)
+	if breaker.Allow() {
+		t.Fatal("half-open breaker allowed a second probe")
+	}
+
+	breaker.Record(true)
+	assertState(t, breaker, BreakerClosed)
+	if !breaker.Allow() {
+		t.Fatal("closed breaker rejected an exchange")
+	}
+}
+
+func TestBreakerReopensAfterFailedProbe(t *testing.T) {
+	breaker := newTestBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: 20 * time.Millisecond})
+	recordAll(breaker, false)
+
+	time.Sleep(30 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("breaker did not allow a probe after the open timeout")
+	}
+	breaker.Record(false)
+	assertState(t, breaker, BreakerOpen)
+
+	// The open timeout starts over from the failed probe
+	if breaker.Allow() {
+		t.Fatal("breaker allowed an exchange right after a failed probe")
+	}
+}
+
+func TestNilBreakerAllowsEverything(t *testing.T) {
+	var breaker *CircuitBreaker
+	breaker.Record(false)
+	if !breaker.Allow() {
+		t.Fatal("nil breaker rejected an exchange")
+	}
+	assertState(t, breaker, BreakerClosed)
+}
+
+func TestClientHoldsMessageWhileBreakerIsOpen(t *testing.T) {
+	name := memName(t, "")
+	breaker := newTestBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: 30 * time.Millisecond})
+	client := NewClient(ClientConfig{ID: "1", Dialer: &memDialer{name: name}, Breaker: breaker})
+
+	// The server is not listening yet: the failure trips the breaker
+	if client.deliverMessage(1) {
+		t.Fatal("message delivered without a server")
+	}
+	assertState(t, breaker, BreakerOpen)
+
+	// While open the message is held without reaching the server
+	server := startEchoServer(t, listenMem(t, name))
+	if client.deliverMessage(1) {
+		t.Fatal("message delivered while the breaker is open")
+	}
+	msg := "[CLIENT 1] Message N°1\n"
+	if server.count(msg) != 0 {
+		t.Fatal("message reached the server while the breaker is open")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if !client.deliverMessage(1) {
+		t.Fatal("held message not delivered after the breaker closed")
+	}
+	assertState(t, breaker, BreakerClosed)
+	if got := server.count(msg); got != 1 {
+		t.Fatalf("server received the held message %v times, want 1", got)
+	}
+}
+
+func TestStalledServerIsRecordedAsFailure(t *testing.T) {
+	name := memName(t, "")
+	startStalledServer(t, listenMem(t, name))
+	breaker := newTestBreaker(BreakerConfig{ConsecutiveFailures: 1})
+	client := NewClient(ClientConfig{ID: "1", Dialer: &memDialer{name: name}, Breaker: breaker, Timeout: testTimeout})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.exchangeMessage(1, "[CLIENT 1] Message N°1\n")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("exchange error = %v, want a timeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("exchange with a stalled server did not time out")
+	}
+	assertState(t, breaker, BreakerOpen)
+}
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Directions of the frames stored in a capture file
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// CaptureEntry Frame recorded in a capture file. Conn identifies the
+// connection the frame was exchanged on. A message resent after a
+// failure goes on a new connection, so it keeps its MsgID but gets a
+// different Conn. Data is stored base64 encoded. When the connection
+// failed mid frame, Data holds the bytes actually transferred and
+// Error the failure
+type CaptureEntry struct {
+	Time      time.Time `json:"time"`
+	Conn      int       `json:"conn"`
+	MsgID     int       `json:"msg_id"`
+	Direction string    `json:"direction"`
+	Data      []byte    `json:"data"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Recorder Tees every frame sent and received by the client into a
+// capture file, one JSON entry per line
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewRecorder Creates the capture file at path, truncating it if it
+// already exists
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create capture file %v", path)
+	}
+	return &Recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Record Appends a frame to the capture file, along with the error
+// that cut it short if frameErr is not nil. A recorder failure must
+// not break the session being captured, so errors are returned for the
+// caller to log
+func (r *Recorder) Record(conn int, msgID int, direction string, data []byte, frameErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := CaptureEntry{
+		Time:      time.Now(),
+		Conn:      conn,
+		MsgID:     msgID,
+		Direction: direction,
+		Data:      data,
+	}
+	if frameErr != nil {
+		entry.Error = frameErr.Error()
+	}
+	return r.encoder.Encode(entry)
+}
+
+// Close Closes the capture file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// LoadCapture Reads every entry of the capture file at path
+func LoadCapture(path string) ([]CaptureEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not open capture file %v", path)
+	}
+	defer file.Close()
+
+	var entries []CaptureEntry
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		var entry CaptureEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, errors.Wrapf(err, "Could not parse capture file %v", path)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// captureSessions Groups capture entries by connection, keeping the
+// order in which connections were opened
+func captureSessions(entries []CaptureEntry) [][]CaptureEntry {
+	var sessions [][]CaptureEntry
+	index := map[int]int{}
+	for _, entry := range entries {
+		i, ok := index[entry.Conn]
+		if !ok {
+			i = len(sessions)
+			index[entry.Conn] = i
+			sessions = append(sessions, nil)
+		}
+		sessions[i] = append(sessions[i], entry)
+	}
+	return sessions
+}
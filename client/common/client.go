@@ -2,6 +2,7 @@ package common
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -15,36 +16,80 @@ type ClientConfig struct {
 	ServerAddress string
 	LoopLapse     time.Duration
 	LoopPeriod    time.Duration
+	// Timeout bounds the dial and every exchange with the server. A
+	// server that stops answering fails the exchange instead of
+	// blocking the client. Zero means no timeout
+	Timeout       time.Duration
+	Dialer        Dialer
+	Recorder      *Recorder
+	HexDumpLength int
+	SendAttempts  int
+	Breaker       *CircuitBreaker
 }
 
+// errBreakerOpen Error returned when the circuit breaker does not let
+// an exchange with the server through
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// errEchoMismatch Error returned when the server response differs from
+// the message sent, e.g. because it was corrupted on the wire
+var errEchoMismatch = errors.New("echo mismatch")
+
 // Client Entity that encapsulates how
 type Client struct {
 	config ClientConfig
+	dialer Dialer
 	conn   net.Conn
+	// connID autoincremental ID of the current connection, used to
+	// group frames in the capture file
+	connID int
 }
 
 // NewClient Initializes a new client receiving the configuration
-// as a parameter
+// as a parameter. If no Dialer is configured the client connects
+// to ServerAddress over plain TCP
 func NewClient(config ClientConfig) *Client {
+	dialer := config.Dialer
+	if dialer == nil {
+		dialer = &netDialer{network: "tcp", address: config.ServerAddress, timeout: config.Timeout}
+	}
+
+	if config.SendAttempts < 1 {
+		config.SendAttempts = 1
+	}
+
 	client := &Client{
 		config: config,
+		dialer: dialer,
 	}
 	return client
 }
 
-// CreateClientSocket Initializes client socket. In case of
-// failure, error is printed in stdout/stderr and exit 1
-// is returned
-func (c *Client) createClientSocket() error {
-	conn, err := net.Dial("tcp", c.config.ServerAddress)
+// CreateClientSocket Initializes client socket through dialer. In case
+// of failure, error is printed in stdout/stderr and exit 1 is returned,
+// unless a circuit breaker or several servers to fail over between are
+// configured: then the error is returned, to be counted as a failure
+// and retried later with the same message. Every connection carries one exchange,
+// so its deadline is set here to bound the whole exchange by Timeout
+func (c *Client) createClientSocket(dialer Dialer) error {
+	conn, err := dialer.Dial()
 	if err != nil {
+		_, failover := c.dialer.(exchangeDialer)
+		if c.config.Breaker != nil || failover {
+			log.Errorf("action: connect | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			return err
+		}
 		log.Fatalf(
 	        "action: connect | result: fail | client_id: %v | error: %v",
 			c.config.ID,
 			err,
 		)
 	}
+	if c.config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.config.Timeout))
+	}
 	c.conn = conn
+	c.connID++
 	return nil
 }
 
@@ -65,35 +110,135 @@ loop:
 		default:
 		}
 
-		// Create the connection the server in every loop iteration. Send an
-		c.createClientSocket()
+		// A message that was not delivered is sent again with the same
+		// msgID in the next iteration
+		if c.deliverMessage(msgID) {
+			msgID++
+		}
+
+		// Wait a time between sending one message and the next one
+		time.Sleep(c.config.LoopPeriod)
+	}
+
+	log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
+}
 
-		// TODO: Modify the send to avoid short-write
-		fmt.Fprintf(
-			c.conn,
-			"[CLIENT %v] Message N°%v\n",
+// deliverMessage Sends message msgID to the server and checks it is
+// echoed back unchanged. It returns false if the message was not
+// delivered and must be sent again
+func (c *Client) deliverMessage(msgID int) bool {
+	sent := fmt.Sprintf("[CLIENT %v] Message N°%v\n", c.config.ID, msgID)
+	msg, err := c.exchangeMessage(msgID, sent)
+	if err == errBreakerOpen {
+		log.Warnf("action: hold_message | result: in_progress | client_id: %v | msg_id: %v | breaker_state: %v",
 			c.config.ID,
 			msgID,
+			c.config.Breaker.State(),
 		)
-		msg, err := bufio.NewReader(c.conn).ReadString('\n')
-		msgID++
-		c.conn.Close()
+		return false
+	}
+	if err != nil {
+		log.Errorf("action: receive_message | result: fail | client_id: %v | msg_id: %v | error: %v",
+			c.config.ID,
+			msgID,
+			err,
+		)
+		return false
+	}
 
-		if err != nil {
-			log.Errorf("action: receive_message | result: fail | client_id: %v | error: %v",
-                c.config.ID,
-				err,
-			)
-			return
+	log.Infof("action: receive_message | result: success | client_id: %v | msg: %v",
+		c.config.ID,
+		msg,
+	)
+	return true
+}
+
+// exchangeMessage Sends msg and waits for the server response, creating
+// the connection to the server in every call. If the exchange fails it
+// is retried, keeping the same msgID, up to SendAttempts times. With
+// several servers configured every retry goes to a server not tried
+// yet in this exchange. A
+// response that differs from msg counts as a failed attempt. Every
+// attempt goes through the circuit breaker, if one is configured
+func (c *Client) exchangeMessage(msgID int, msg string) (string, error) {
+	dialer := c.dialer
+	if exchange, ok := dialer.(exchangeDialer); ok {
+		dialer = exchange.Exchange()
+	}
+
+	var response string
+	var err error
+	for attempt := 1; ; attempt++ {
+		if !c.config.Breaker.Allow() {
+			return "", errBreakerOpen
+		}
+		if err = c.createClientSocket(dialer); err == nil {
+			err = c.sendMessage(msgID, msg)
+			if err == nil {
+				response, err = c.receiveMessage(msgID)
+			}
+			if err == nil && response != msg {
+				err = fmt.Errorf("%w: %q", errEchoMismatch, response)
+			}
+			c.conn.Close()
 		}
-		log.Infof("action: receive_message | result: success | client_id: %v | msg: %v",
-            c.config.ID,
-            msg,
-        )
+		c.config.Breaker.Record(err == nil)
 
-		// Wait a time between sending one message and the next one
-		time.Sleep(c.config.LoopPeriod)
+		if err == nil || attempt >= c.config.SendAttempts {
+			return response, err
+		}
+		log.Warnf("action: resend_message | result: in_progress | client_id: %v | msg_id: %v | attempt: %v | error: %v",
+			c.config.ID,
+			msgID,
+			attempt,
+			err,
+		)
 	}
+}
 
-	log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
+// sendMessage Writes msg to the current connection, recording it in
+// the capture file if one is configured. If the write fails, the bytes
+// that were sent are traced and recorded along with the error
+func (c *Client) sendMessage(msgID int, msg string) error {
+	n, err := writeAll(c.conn, []byte(msg))
+	c.traceFrame(msgID, DirectionSent, []byte(msg[:n]), err)
+	c.record(msgID, DirectionSent, []byte(msg[:n]), err)
+	return err
+}
+
+// receiveMessage Reads a message from the current connection, recording
+// it in the capture file if one is configured. If the read fails, the
+// partial line received is traced and recorded along with the error
+func (c *Client) receiveMessage(msgID int) (string, error) {
+	msg, err := bufio.NewReader(c.conn).ReadString('\n')
+	c.traceFrame(msgID, DirectionReceived, []byte(msg), err)
+	c.record(msgID, DirectionReceived, []byte(msg), err)
+	if err != nil {
+		return "", err
+	}
+	return msg, nil
+}
+
+func (c *Client) record(msgID int, direction string, data []byte, frameErr error) {
+	if c.config.Recorder == nil {
+		return
+	}
+	if err := c.config.Recorder.Record(c.connID, msgID, direction, data, frameErr); err != nil {
+		log.Errorf("action: capture_frame | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	}
+}
+
+// writeAll Writes the whole buffer to conn. A single Write may send
+// fewer bytes than requested, so it is retried until every byte is
+// sent or an error occurs. It returns the number of bytes written
+func writeAll(conn net.Conn, b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := conn.Write(b[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
 }
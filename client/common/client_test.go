@@ -0,0 +1,82 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startPartialServer Accepts connections on listener, reads the message
+// and answers only its first bytes before closing the connection
+func startPartialServer(t *testing.T, listener *MemListener, answer string) {
+	t.Helper()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 1)
+			for buf[0] != '\n' {
+				if _, err := conn.Read(buf); err != nil {
+					break
+				}
+			}
+			writeAll(conn, []byte(answer))
+			conn.Close()
+		}
+	}()
+}
+
+func TestPartialFramesAreTracedAndRecorded(t *testing.T) {
+	const msg = "[CLIENT 1] Message N°1\n"
+	tests := []struct {
+		name      string
+		faults    FaultConfig
+		direction string
+		partial   string
+	}{
+		{"send cut short", FaultConfig{DropAfterBytes: 5}, DirectionSent, msg[:5]},
+		{"response cut short", FaultConfig{}, DirectionReceived, "[CLIENT"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			name := memName(t, "")
+			startPartialServer(t, listenMem(t, name), "[CLIENT")
+			hook := hookLogs(t, log.TraceLevel, "action: frame |")
+
+			captureFile := filepath.Join(t.TempDir(), "capture.jsonl")
+			recorder, err := NewRecorder(captureFile)
+			if err != nil {
+				t.Fatalf("NewRecorder: %v", err)
+			}
+			dialer := NewFaultDialer(&memDialer{name: name}, test.faults)
+			client := NewClient(ClientConfig{ID: "1", Dialer: dialer, Recorder: recorder})
+			if _, err := client.exchangeMessage(1, msg); err == nil {
+				t.Fatal("exchange succeeded, want the connection to fail")
+			}
+			recorder.Close()
+
+			entries, err := LoadCapture(captureFile)
+			if err != nil {
+				t.Fatalf("LoadCapture: %v", err)
+			}
+			last := entries[len(entries)-1]
+			if last.Direction != test.direction || string(last.Data) != test.partial || last.Error == "" {
+				t.Errorf("last capture entry = %+v, want %v frame %q with an error", last, test.direction, test.partial)
+			}
+
+			traces := hook.logged()
+			trace := traces[len(traces)-1]
+			if !strings.Contains(trace, "result: fail") || !strings.Contains(trace, "direction: "+test.direction) ||
+				!strings.Contains(trace, fmt.Sprintf("length: %v |", len(test.partial))) {
+				t.Errorf("last frame trace = %q, want the failed %v frame of %v bytes", trace, test.direction, len(test.partial))
+			}
+		})
+	}
+}
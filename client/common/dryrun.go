@@ -0,0 +1,72 @@
+package common
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DryRun Dialer that never connects to the server. Every frame the
+// client sends is written to out instead of a socket and answered
+// locally the way the echo server would, so a run can be checked
+// without touching the shared server
+type DryRun struct {
+	mu     sync.Mutex
+	out    io.Writer
+	frames int
+	bytes  int
+}
+
+// NewDryRun Returns a dry run Dialer writing frames to out
+func NewDryRun(out io.Writer) *DryRun {
+	return &DryRun{out: out}
+}
+
+// Dial Returns the client end of an in-memory connection served by
+// the dry run
+func (d *DryRun) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go d.serve(server)
+	return client, nil
+}
+
+func (d *DryRun) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		d.mu.Lock()
+		_, err = d.out.Write(frame)
+		d.frames++
+		d.bytes += len(frame)
+		d.mu.Unlock()
+		if err != nil {
+			log.Errorf("action: dry_run_write | result: fail | error: %v", err)
+			return
+		}
+
+		if _, err := writeAll(conn, frame); err != nil {
+			return
+		}
+	}
+}
+
+// Report Logs how many frames and bytes a real run would have sent
+func (d *DryRun) Report(clientID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	log.Infof("action: dry_run | result: success | client_id: %v | frames: %v | bytes: %v",
+		clientID,
+		d.frames,
+		d.bytes,
+	)
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDryRunWritesFramesAndReportsThem(t *testing.T) {
+	hook := hookLogs(t, log.InfoLevel, "action: dry_run |")
+	var out bytes.Buffer
+	dryRun := NewDryRun(&out)
+	client := NewClient(ClientConfig{ID: "1", Dialer: dryRun})
+
+	var want strings.Builder
+	for msgID := 1; msgID <= 3; msgID++ {
+		if !client.deliverMessage(msgID) {
+			t.Fatalf("message %v not echoed by the dry run", msgID)
+		}
+		fmt.Fprintf(&want, "[CLIENT 1] Message N°%v\n", msgID)
+	}
+	dryRun.Report("1")
+
+	if out.String() != want.String() {
+		t.Errorf("dry run output = %q, want %q", out.String(), want.String())
+	}
+	reports := hook.logged()
+	if len(reports) != 1 {
+		t.Fatalf("dry run reports = %q, want one", reports)
+	}
+	counts := fmt.Sprintf("frames: 3 | bytes: %v", want.Len())
+	if !strings.Contains(reports[0], counts) {
+		t.Errorf("report = %q, want %q", reports[0], counts)
+	}
+}
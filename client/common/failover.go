@@ -0,0 +1,229 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Server selection policies supported by FailoverDialer
+const (
+	PolicyOrdered    = "ordered"
+	PolicyRoundRobin = "round-robin"
+	PolicyLatency    = "latency"
+)
+
+// latencyWeight Weight of the newest sample in the moving average of
+// the connect latency of every endpoint
+const latencyWeight = 0.3
+
+// Endpoint Server the client can connect to
+type Endpoint struct {
+	Address string
+	Dialer  Dialer
+}
+
+// endpointHealth Health tracked for every endpoint. An endpoint is
+// down after a failure and is not tried again, unless every other
+// endpoint is down too, until downUntil
+type endpointHealth struct {
+	Endpoint
+	latency   time.Duration
+	downUntil time.Time
+	down      bool
+}
+
+// FailoverDialer Dialer that connects to one of several servers. The
+// order in which servers are tried depends on the policy. A server that
+// fails to connect, or whose connection fails while in use, is put on
+// cool-down and the next one is tried
+type FailoverDialer struct {
+	mu        sync.Mutex
+	endpoints []*endpointHealth
+	policy    string
+	cooldown  time.Duration
+	next      int
+}
+
+// NewFailoverDialer Returns a Dialer spreading connections across
+// endpoints with the given policy
+func NewFailoverDialer(endpoints []Endpoint, policy string, cooldown time.Duration) (*FailoverDialer, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no server endpoints configured")
+	}
+	switch policy {
+	case PolicyOrdered, PolicyRoundRobin, PolicyLatency:
+	default:
+		return nil, fmt.Errorf("unsupported server selection policy %q", policy)
+	}
+
+	d := &FailoverDialer{policy: policy, cooldown: cooldown}
+	for _, endpoint := range endpoints {
+		d.endpoints = append(d.endpoints, &endpointHealth{Endpoint: endpoint})
+	}
+	return d, nil
+}
+
+// exchangeDialer Dialer able to spread the attempts of a single
+// exchange with the server across different servers
+type exchangeDialer interface {
+	Exchange() Dialer
+}
+
+// Dial Connects to the first reachable endpoint in policy order. The
+// error of every attempt is returned if none of them could be reached
+func (d *FailoverDialer) Dial() (net.Conn, error) {
+	return d.dial(nil)
+}
+
+// Exchange Returns a Dialer for the attempts of a single exchange.
+// Each of its dials only tries servers not tried yet in the exchange,
+// whatever their cool-down, so a message resent after a failure always
+// reaches the next server. Once every server was tried they are all
+// candidates again
+func (d *FailoverDialer) Exchange() Dialer {
+	return &failoverExchange{dialer: d, tried: map[*endpointHealth]bool{}}
+}
+
+// failoverExchange Dialer returned by FailoverDialer.Exchange
+type failoverExchange struct {
+	dialer *FailoverDialer
+	tried  map[*endpointHealth]bool
+}
+
+func (e *failoverExchange) Dial() (net.Conn, error) {
+	return e.dialer.dial(e.tried)
+}
+
+// dial Connects to the first reachable candidate. If tried is not nil,
+// endpoints in it are skipped and the endpoints dialed are added to it
+func (d *FailoverDialer) dial(tried map[*endpointHealth]bool) (net.Conn, error) {
+	candidates := d.candidates()
+	if tried != nil {
+		var untried []*endpointHealth
+		for _, endpoint := range candidates {
+			if !tried[endpoint] {
+				untried = append(untried, endpoint)
+			}
+		}
+		if len(untried) > 0 {
+			candidates = untried
+		}
+	}
+
+	var failures []string
+	for _, endpoint := range candidates {
+		if tried != nil {
+			tried[endpoint] = true
+		}
+		start := time.Now()
+		conn, err := endpoint.Dialer.Dial()
+		if err != nil {
+			d.markDown(endpoint, err)
+			failures = append(failures, fmt.Sprintf("%v: %v", endpoint.Address, err))
+			continue
+		}
+		d.markUp(endpoint, time.Since(start))
+		return &endpointConn{Conn: conn, dialer: d, endpoint: endpoint}, nil
+	}
+	return nil, fmt.Errorf("every server failed: %v", strings.Join(failures, "; "))
+}
+
+// candidates Returns the endpoints in the order they must be tried.
+// Healthy endpoints follow the policy. Endpoints on cool-down are
+// left last, soonest to recover first, so the client keeps trying
+// even when every server has failed recently
+func (d *FailoverDialer) candidates() []*endpointHealth {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var healthy, cooling []*endpointHealth
+	for i := range d.endpoints {
+		endpoint := d.endpoints[i]
+		if d.policy == PolicyRoundRobin {
+			endpoint = d.endpoints[(d.next+i)%len(d.endpoints)]
+		}
+		if endpoint.down && now.Before(endpoint.downUntil) {
+			cooling = append(cooling, endpoint)
+		} else {
+			healthy = append(healthy, endpoint)
+		}
+	}
+	d.next = (d.next + 1) % len(d.endpoints)
+
+	if d.policy == PolicyLatency {
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].latency < healthy[j].latency
+		})
+	}
+	sort.SliceStable(cooling, func(i, j int) bool {
+		return cooling[i].downUntil.Before(cooling[j].downUntil)
+	})
+	return append(healthy, cooling...)
+}
+
+func (d *FailoverDialer) markDown(endpoint *endpointHealth, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	endpoint.down = true
+	endpoint.downUntil = time.Now().Add(d.cooldown)
+	log.Warnf("action: server_down | result: success | server_address: %v | cooldown: %v | error: %v",
+		endpoint.Address,
+		d.cooldown,
+		err,
+	)
+}
+
+func (d *FailoverDialer) markUp(endpoint *endpointHealth, latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if endpoint.latency == 0 {
+		endpoint.latency = latency
+	} else {
+		endpoint.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(endpoint.latency))
+	}
+	if endpoint.down {
+		endpoint.down = false
+		log.Infof("action: server_up | result: success | server_address: %v | latency: %v", endpoint.Address, endpoint.latency)
+	}
+}
+
+// endpointConn Connection to an endpoint of a FailoverDialer. Any
+// read or write error puts the endpoint on cool-down, so the message
+// in flight is resent to the next server
+type endpointConn struct {
+	net.Conn
+	dialer   *FailoverDialer
+	endpoint *endpointHealth
+	once     sync.Once
+}
+
+func (c *endpointConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *endpointConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *endpointConn) fail(err error) {
+	c.once.Do(func() {
+		c.dialer.markDown(c.endpoint, err)
+	})
+}
@@ -0,0 +1,303 @@
+package common
+
+import (
+	"crypto/tls"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memEndpoints Returns an endpoint per name dialing mem://<test>-<name>.
+// Listeners are not created, so endpoints are unreachable until a test
+// listens on them
+func memEndpoints(t *testing.T, names ...string) []Endpoint {
+	var endpoints []Endpoint
+	for _, name := range names {
+		address := "mem://" + memName(t, name)
+		dialer, err := NewDialer(address, 0)
+		if err != nil {
+			t.Fatalf("NewDialer(%q): %v", address, err)
+		}
+		endpoints = append(endpoints, Endpoint{Address: address, Dialer: dialer})
+	}
+	return endpoints
+}
+
+func newFailoverDialer(t *testing.T, endpoints []Endpoint, policy string, cooldown time.Duration) *FailoverDialer {
+	t.Helper()
+	dialer, err := NewFailoverDialer(endpoints, policy, cooldown)
+	if err != nil {
+		t.Fatalf("NewFailoverDialer: %v", err)
+	}
+	return dialer
+}
+
+// candidateOrder Returns the addresses of the endpoints in the order
+// the dialer would try them next, without the test name prefix
+func candidateOrder(t *testing.T, dialer *FailoverDialer) string {
+	var order []string
+	for _, endpoint := range dialer.candidates() {
+		order = append(order, strings.TrimPrefix(endpoint.Address, "mem://"+memName(t, "")+"-"))
+	}
+	return strings.Join(order, ",")
+}
+
+func TestNewFailoverDialerValidatesConfig(t *testing.T) {
+	if _, err := NewFailoverDialer(nil, PolicyOrdered, 0); err == nil {
+		t.Error("NewFailoverDialer without endpoints succeeded")
+	}
+	if _, err := NewFailoverDialer(memEndpoints(t, "a"), "random", 0); err == nil {
+		t.Error("NewFailoverDialer with unknown policy succeeded")
+	}
+}
+
+func TestFailoverPolicyOrder(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{PolicyOrdered, []string{"a,b,c", "a,b,c", "a,b,c"}},
+		{PolicyRoundRobin, []string{"a,b,c", "b,c,a", "c,a,b", "a,b,c"}},
+	}
+
+	for _, test := range tests {
+		dialer := newFailoverDialer(t, memEndpoints(t, "a", "b", "c"), test.policy, time.Minute)
+		for i, want := range test.want {
+			if got := candidateOrder(t, dialer); got != want {
+				t.Errorf("%v: candidates %v = %v, want %v", test.policy, i, got, want)
+			}
+		}
+	}
+}
+
+func TestFailoverLatencyPolicyPrefersFastestServer(t *testing.T) {
+	dialer := newFailoverDialer(t, memEndpoints(t, "a", "b", "c"), PolicyLatency, time.Minute)
+	dialer.endpoints[0].latency = 30 * time.Millisecond
+	dialer.endpoints[1].latency = 10 * time.Millisecond
+	dialer.endpoints[2].latency = 20 * time.Millisecond
+
+	if got := candidateOrder(t, dialer); got != "b,c,a" {
+		t.Fatalf("candidates = %v, want b,c,a", got)
+	}
+}
+
+func TestFailoverSkipsServerOnCooldown(t *testing.T) {
+	endpoints := memEndpoints(t, "a", "b")
+	startEchoServer(t, listenMem(t, memName(t, "b")))
+	dialer := newFailoverDialer(t, endpoints, PolicyOrdered, 50*time.Millisecond)
+
+	// a is unreachable: the dial goes to b and a cools down
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	if got := candidateOrder(t, dialer); got != "b,a" {
+		t.Fatalf("candidates during cool-down = %v, want b,a", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got := candidateOrder(t, dialer); got != "a,b" {
+		t.Fatalf("candidates after cool-down = %v, want a,b", got)
+	}
+}
+
+func TestFailoverTriesCoolingServersLast(t *testing.T) {
+	dialer := newFailoverDialer(t, memEndpoints(t, "a", "b", "c"), PolicyOrdered, time.Minute)
+	now := time.Now()
+	for i, endpoint := range dialer.endpoints {
+		endpoint.down = true
+		endpoint.downUntil = now.Add(time.Duration(3-i) * time.Second)
+	}
+
+	// Every server is down: the one recovering first goes first
+	if got := candidateOrder(t, dialer); got != "c,b,a" {
+		t.Fatalf("candidates = %v, want c,b,a", got)
+	}
+
+	// and they are still dialed as a last resort
+	startEchoServer(t, listenMem(t, memName(t, "b")))
+	conn, err := dialer.Dial()
+	if err != nil {
+		t.Fatalf("Dial with every server cooling down: %v", err)
+	}
+	conn.Close()
+}
+
+func TestFailoverReportsEveryFailure(t *testing.T) {
+	dialer := newFailoverDialer(t, memEndpoints(t, "a", "b"), PolicyOrdered, 0)
+	_, err := dialer.Dial()
+	if err == nil {
+		t.Fatal("Dial without reachable servers succeeded")
+	}
+	for _, name := range []string{"a", "b"} {
+		if !strings.Contains(err.Error(), memName(t, name)) {
+			t.Errorf("error %q does not mention server %v", err, name)
+		}
+	}
+}
+
+func TestFailoverResendsInFlightMessageToNextServer(t *testing.T) {
+	for _, policy := range []string{PolicyOrdered, PolicyRoundRobin, PolicyLatency} {
+		t.Run(policy, func(t *testing.T) {
+			endpoints := memEndpoints(t, "a", "b")
+			deadAccepted := startDeadServer(t, listenMem(t, memName(t, "a")))
+			server := startEchoServer(t, listenMem(t, memName(t, "b")))
+
+			// Without cool-down a failed server is immediately healthy
+			// again, the exchange itself must move to the next one
+			dialer := newFailoverDialer(t, endpoints, policy, 0)
+			client := NewClient(ClientConfig{ID: "1", Dialer: dialer, SendAttempts: len(endpoints)})
+
+			for msgID := 1; msgID <= 3; msgID++ {
+				if !client.deliverMessage(msgID) {
+					t.Fatalf("message %v not delivered", msgID)
+				}
+			}
+			for msgID := 1; msgID <= 3; msgID++ {
+				msg := fmt.Sprintf("[CLIENT 1] Message N°%v\n", msgID)
+				if got := server.count(msg); got != 1 {
+					t.Errorf("server b received %q %v times, want 1", msg, got)
+				}
+			}
+			if policy == PolicyOrdered && deadAccepted() != 3 {
+				t.Errorf("server a accepted %v connections, want 3", deadAccepted())
+			}
+		})
+	}
+}
+
+func TestFailoverResendsMessageStalledOnSilentServer(t *testing.T) {
+	endpoints := memEndpoints(t, "a", "b")
+	startStalledServer(t, listenMem(t, memName(t, "a")))
+	server := startEchoServer(t, listenMem(t, memName(t, "b")))
+
+	// Server a never answers nor closes the connection: only the
+	// exchange timeout tells it apart from a slow server
+	dialer := newFailoverDialer(t, endpoints, PolicyOrdered, time.Minute)
+	client := NewClient(ClientConfig{ID: "1", Dialer: dialer, SendAttempts: len(endpoints), Timeout: testTimeout})
+
+	if !client.deliverMessage(1) {
+		t.Fatal("message not delivered")
+	}
+	if server.count("[CLIENT 1] Message N°1\n") != 1 {
+		t.Fatal("message stalled on server a was not resent to server b")
+	}
+	if !dialer.endpoints[0].down {
+		t.Fatal("stalled server a was not marked down")
+	}
+}
+
+func TestFailoverHoldsMessageWhenEveryServerIsDown(t *testing.T) {
+	endpoints := memEndpoints(t, "a", "b")
+	dialer := newFailoverDialer(t, endpoints, PolicyOrdered, 0)
+	client := NewClient(ClientConfig{ID: "1", Dialer: dialer, SendAttempts: len(endpoints)})
+
+	// Without a breaker a failed dial used to be fatal. With several
+	// servers the message is held and retried by the loop instead
+	if client.deliverMessage(1) {
+		t.Fatal("message delivered without a server")
+	}
+
+	server := startEchoServer(t, listenMem(t, memName(t, "b")))
+	if !client.deliverMessage(1) {
+		t.Fatal("held message not delivered once a server came up")
+	}
+	if got := server.count("[CLIENT 1] Message N°1\n"); got != 1 {
+		t.Fatalf("server b received the held message %v times, want 1", got)
+	}
+}
+
+func TestFailoverVerifiesEachServerWithItsOwnName(t *testing.T) {
+	ca := mustCA(t)
+	names := []string{memName(t, "a"), memName(t, "b")}
+
+	// a presents a certificate for the wrong host, b for its own name
+	wrongConfig, err := ca.ServerConfig(false, "elsewhere")
+	if err != nil {
+		t.Fatalf("ServerConfig: %v", err)
+	}
+	rightConfig, err := ca.ServerConfig(false, names[1])
+	if err != nil {
+		t.Fatalf("ServerConfig: %v", err)
+	}
+	startEchoServer(t, tls.NewListener(listenMem(t, names[0]), wrongConfig))
+	server := startEchoServer(t, tls.NewListener(listenMem(t, names[1]), rightConfig))
+
+	caFile, _, _, err := ca.WriteFiles(t.TempDir(), "1")
+	if err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	tlsConfig, err := LoadTLSConfig(TLSConfig{Enabled: true, CAFile: caFile}, "1")
+	if err != nil {
+		t.Fatalf("LoadTLSConfig: %v", err)
+	}
+
+	endpoints := memEndpoints(t, "a", "b")
+	for i := range endpoints {
+		endpoints[i].Dialer = NewTLSDialer(endpoints[i].Dialer, tlsConfig, endpoints[i].Address)
+	}
+	dialer := newFailoverDialer(t, endpoints, PolicyOrdered, time.Minute)
+	client := NewClient(ClientConfig{ID: "1", Dialer: dialer, SendAttempts: len(endpoints)})
+
+	if !client.deliverMessage(1) {
+		t.Fatal("message not delivered")
+	}
+	if server.count("[CLIENT 1] Message N°1\n") != 1 {
+		t.Fatal("message did not reach server b")
+	}
+	if !dialer.endpoints[0].down {
+		t.Fatal("server a failing TLS verification was not marked down")
+	}
+}
+
+func TestCaptureKeepsResentMessageOnSeparateConnections(t *testing.T) {
+	endpoints := memEndpoints(t, "a", "b")
+	startDeadServer(t, listenMem(t, memName(t, "a")))
+	startEchoServer(t, listenMem(t, memName(t, "b")))
+
+	captureFile := filepath.Join(t.TempDir(), "capture.jsonl")
+	recorder, err := NewRecorder(captureFile)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	dialer := newFailoverDialer(t, endpoints, PolicyOrdered, 0)
+	client := NewClient(ClientConfig{ID: "1", Dialer: dialer, SendAttempts: len(endpoints), Recorder: recorder})
+	if !client.deliverMessage(1) {
+		t.Fatal("message not delivered")
+	}
+	recorder.Close()
+
+	entries, err := LoadCapture(captureFile)
+	if err != nil {
+		t.Fatalf("LoadCapture: %v", err)
+	}
+	sessions := captureSessions(entries)
+	if len(sessions) != 2 {
+		t.Fatalf("capture has %v sessions, want 2: %+v", len(sessions), entries)
+	}
+	if len(sessions[0]) != 2 || sessions[0][0].Direction != DirectionSent ||
+		len(sessions[0][1].Data) != 0 || sessions[0][1].Error == "" {
+		t.Errorf("failed session = %+v, want a sent frame and an empty failed response", sessions[0])
+	}
+	if len(sessions[1]) != 2 || sessions[1][1].Direction != DirectionReceived {
+		t.Errorf("resent session = %+v, want sent and received frames", sessions[1])
+	}
+	for _, entry := range entries {
+		if entry.MsgID != 1 {
+			t.Errorf("entry %+v has msg_id %v, want 1", entry, entry.MsgID)
+		}
+	}
+
+	// Replaying against a healthy server matches every response but the
+	// one the dead server never sent
+	replayName := memName(t, "replay")
+	startEchoServer(t, listenMem(t, replayName))
+	replayer := NewClient(ClientConfig{ID: "1", Dialer: &memDialer{name: replayName}})
+	mismatches, err := replayer.Replay(entries)
+	if err != nil || mismatches != 1 {
+		t.Fatalf("Replay = %v, %v, want 1 mismatch", mismatches, err)
+	}
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// errInjectedDrop Error returned once the connection was dropped by
+// the fault injector
+var errInjectedDrop = errors.New("connection dropped by fault injection")
+
+// FaultConfig Faults injected on every connection opened by the client.
+// It is used to check the client survives a bad network and must never
+// be enabled in a real deployment
+type FaultConfig struct {
+	// ShortWrites makes every Write send a single byte
+	ShortWrites bool
+	// MaxReadSize limits the bytes returned by every Read. 0 means no limit
+	MaxReadSize int
+	// MaxDelay is the upper bound of a random delay added before every
+	// Read and Write
+	MaxDelay time.Duration
+	// DropAfterBytes closes the connection once that many bytes were
+	// sent and received in total. 0 means never
+	DropAfterBytes int
+	// GarbleRate is the probability of corrupting every byte transferred
+	GarbleRate float64
+	// Seed of the random source, so a failing run can be reproduced
+	Seed int64
+}
+
+// faultDialer Dialer that wraps the connections of another Dialer
+// with a faultConn
+type faultDialer struct {
+	dialer Dialer
+	config FaultConfig
+	rand   *rand.Rand
+	mu     sync.Mutex
+}
+
+// NewFaultDialer Returns a Dialer whose connections inject the faults
+// described by config
+func NewFaultDialer(dialer Dialer, config FaultConfig) Dialer {
+	return &faultDialer{
+		dialer: dialer,
+		config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+func (d *faultDialer) Dial() (net.Conn, error) {
+	conn, err := d.dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every connection gets its own source derived from the dialer one,
+	// so runs with the same seed inject the same faults
+	d.mu.Lock()
+	seed := d.rand.Int63()
+	d.mu.Unlock()
+
+	return &faultConn{
+		Conn:   conn,
+		config: d.config,
+		rand:   rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// faultConn net.Conn that injects faults on reads and writes
+type faultConn struct {
+	net.Conn
+	config      FaultConfig
+	rand        *rand.Rand
+	mu          sync.Mutex
+	transferred int
+	dropped     bool
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	c.delay()
+	if c.config.MaxReadSize > 0 && len(b) > c.config.MaxReadSize {
+		b = b[:c.config.MaxReadSize]
+	}
+	b, err := c.limit(b)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(b)
+	c.consume(n)
+	c.garble(b[:n])
+	return n, err
+}
+
+// Write With ShortWrites the buffer reaches the underlying connection
+// one byte per call, so the peer sees it split on the wire. As
+// io.Writer requires, fewer than len(b) bytes are only reported
+// together with an error
+func (c *faultConn) Write(b []byte) (int, error) {
+	chunk := len(b)
+	if c.config.ShortWrites {
+		chunk = 1
+	}
+
+	written := 0
+	for written < len(b) {
+		c.delay()
+		end := written + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+		part, err := c.limit(b[written:end])
+		if err != nil {
+			return written, err
+		}
+
+		// Copy before garbling so the caller's buffer is left untouched
+		out := append([]byte(nil), part...)
+		c.garble(out)
+		n, err := c.Conn.Write(out)
+		c.consume(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// limit Trims b to the bytes left before the connection must be
+// dropped. Once the whole budget was transferred the connection is
+// closed and every following call fails
+func (c *faultConn) limit(b []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dropped {
+		return nil, errInjectedDrop
+	}
+	if c.config.DropAfterBytes <= 0 {
+		return b, nil
+	}
+
+	left := c.config.DropAfterBytes - c.transferred
+	if left <= 0 {
+		c.dropped = true
+		c.Conn.Close()
+		return nil, errInjectedDrop
+	}
+	if len(b) > left {
+		b = b[:left]
+	}
+	return b, nil
+}
+
+// consume Counts the bytes actually moved by a read or write against
+// the drop budget
+func (c *faultConn) consume(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transferred += n
+}
+
+func (c *faultConn) delay() {
+	if c.config.MaxDelay <= 0 {
+		return
+	}
+	c.mu.Lock()
+	d := time.Duration(c.rand.Int63n(int64(c.config.MaxDelay)))
+	c.mu.Unlock()
+	time.Sleep(d)
+}
+
+func (c *faultConn) garble(b []byte) {
+	if c.config.GarbleRate <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range b {
+		if c.rand.Float64() < c.config.GarbleRate {
+			b[i] ^= byte(1 + c.rand.Intn(255))
+		}
+	}
+}
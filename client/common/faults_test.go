@@ -0,0 +1,218 @@
+package common
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resilienceMessages Messages delivered in every resilience test
+const resilienceMessages = 30
+
+// resilienceMaxAttempts Attempts allowed to deliver a single message
+// before the test gives up
+const resilienceMaxAttempts = 200
+
+// alternatingDialer Uses the faulty dialer on every other connection,
+// so faults that break every connection the same way, like a drop
+// after a fixed number of bytes, still let messages through on retry
+type alternatingDialer struct {
+	mu      sync.Mutex
+	faulty  Dialer
+	healthy Dialer
+	dials   int
+}
+
+func (d *alternatingDialer) Dial() (net.Conn, error) {
+	d.mu.Lock()
+	d.dials++
+	faulty := d.dials%2 == 1
+	d.mu.Unlock()
+
+	if faulty {
+		return d.faulty.Dial()
+	}
+	return d.healthy.Dial()
+}
+
+func TestFaultConnShortWritesReportWholeBuffer(t *testing.T) {
+	client, server := net.Pipe()
+	conn := &faultConn{Conn: client, config: FaultConfig{ShortWrites: true}}
+
+	received := make(chan int)
+	go func() {
+		total := 0
+		buf := make([]byte, 16)
+		for total < 5 {
+			n, err := server.Read(buf)
+			if err != nil {
+				break
+			}
+			if n != 1 {
+				t.Errorf("peer read %v bytes at once, want 1", n)
+			}
+			total += n
+		}
+		received <- total
+	}()
+
+	n, err := conn.Write([]byte("hello"))
+	if n != 5 || err != nil {
+		t.Fatalf("Write = %v, %v, want 5, nil", n, err)
+	}
+	if total := <-received; total != 5 {
+		t.Fatalf("peer received %v bytes, want 5", total)
+	}
+}
+
+func TestFaultConnDropCountsTransferredBytes(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &faultConn{Conn: client, config: FaultConfig{DropAfterBytes: 10}}
+
+	go server.Write([]byte("abcd"))
+	buf := make([]byte, 100)
+	n, err := conn.Read(buf)
+	if n != 4 || err != nil {
+		t.Fatalf("Read = %v, %v, want 4, nil", n, err)
+	}
+
+	// 6 bytes of the budget are left: a bigger write is cut there
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	n, err = conn.Write([]byte("0123456789"))
+	if n != 6 || err != errInjectedDrop {
+		t.Fatalf("Write = %v, %v, want 6, errInjectedDrop", n, err)
+	}
+	if _, err := conn.Read(buf); err != errInjectedDrop {
+		t.Fatalf("Read after drop error = %v, want errInjectedDrop", err)
+	}
+}
+
+func TestClientSurvivesFaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		faults      FaultConfig
+		alternating bool
+	}{
+		{"short writes", FaultConfig{ShortWrites: true}, false},
+		{"partial reads", FaultConfig{MaxReadSize: 1}, false},
+		{"delays", FaultConfig{MaxDelay: time.Millisecond, Seed: 1}, false},
+		{"garbled bytes", FaultConfig{GarbleRate: 0.02, Seed: 2}, false},
+		{"drop while sending", FaultConfig{DropAfterBytes: 10}, true},
+		{"drop while receiving", FaultConfig{DropAfterBytes: 30}, true},
+		{"everything", FaultConfig{ShortWrites: true, MaxReadSize: 3, MaxDelay: 100 * time.Microsecond, GarbleRate: 0.01, Seed: 3}, false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			name := memName(t, "")
+			server := startEchoServer(t, listenMem(t, name))
+
+			healthy := &memDialer{name: name}
+			var dialer Dialer = NewFaultDialer(healthy, test.faults)
+			if test.alternating {
+				dialer = &alternatingDialer{faulty: dialer, healthy: healthy}
+			}
+
+			captureFile := filepath.Join(t.TempDir(), "capture.jsonl")
+			recorder, err := NewRecorder(captureFile)
+			if err != nil {
+				t.Fatalf("NewRecorder: %v", err)
+			}
+			client := NewClient(ClientConfig{ID: "1", Dialer: dialer, Recorder: recorder, Timeout: testTimeout})
+
+			for msgID := 1; msgID <= resilienceMessages; msgID++ {
+				attempts := 1
+				for !client.deliverMessage(msgID) {
+					if attempts++; attempts > resilienceMaxAttempts {
+						t.Fatalf("message %v not delivered after %v attempts", msgID, resilienceMaxAttempts)
+					}
+				}
+			}
+			recorder.Close()
+
+			assertDeliveredOnce(t, captureFile, server)
+		})
+	}
+}
+
+// assertDeliveredOnce Checks that every message reached the server
+// intact and that the client accepted exactly one intact echo of
+// each message, in order, and never a corrupted one
+func assertDeliveredOnce(t *testing.T, captureFile string, server *echoServer) {
+	t.Helper()
+	entries, err := LoadCapture(captureFile)
+	if err != nil {
+		t.Fatalf("LoadCapture: %v", err)
+	}
+
+	var delivered []string
+	var sent []byte
+	for _, entry := range entries {
+		if entry.Direction == DirectionSent {
+			sent = entry.Data
+			continue
+		}
+		if entry.Error == "" && string(entry.Data) == string(sent) {
+			delivered = append(delivered, string(sent))
+		}
+	}
+
+	if len(delivered) != resilienceMessages {
+		t.Fatalf("client accepted %v echoes, want %v", len(delivered), resilienceMessages)
+	}
+	for i, msg := range delivered {
+		want := fmt.Sprintf("[CLIENT 1] Message N°%v\n", i+1)
+		if msg != want {
+			t.Fatalf("echo %v accepted = %q, want %q", i+1, msg, want)
+		}
+		if server.count(want) == 0 {
+			t.Fatalf("message %q never reached the server intact", want)
+		}
+	}
+}
+
+func TestTLSSurvivesShortWritesAndPartialReads(t *testing.T) {
+	ca := mustCA(t)
+	serverConfig, err := ca.ServerConfig(false, "localhost")
+	if err != nil {
+		t.Fatalf("ServerConfig: %v", err)
+	}
+	name := memName(t, "")
+	startEchoServer(t, tls.NewListener(listenMem(t, name), serverConfig))
+
+	caFile, _, _, err := ca.WriteFiles(t.TempDir(), "1")
+	if err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	tlsConfig, err := LoadTLSConfig(TLSConfig{Enabled: true, CAFile: caFile, ServerName: "localhost"}, "1")
+	if err != nil {
+		t.Fatalf("LoadTLSConfig: %v", err)
+	}
+
+	dialer := NewFaultDialer(&memDialer{name: name}, FaultConfig{ShortWrites: true, MaxReadSize: 1})
+	client := NewClient(ClientConfig{ID: "1", Dialer: NewTLSDialer(dialer, tlsConfig, "mem://"+name)})
+
+	done := make(chan bool)
+	go func() { done <- client.deliverMessage(1) }()
+	select {
+	case delivered := <-done:
+		if !delivered {
+			t.Fatal("message not delivered over TLS")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TLS exchange over short writes did not finish")
+	}
+}
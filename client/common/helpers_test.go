@@ -0,0 +1,208 @@
+package common
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// testTimeout Exchange timeout of the clients exposed to faults. A
+// client whose newline was garbled or dropped would otherwise wait
+// forever for the echo. The test servers set no deadline of their own
+const testTimeout = 50 * time.Millisecond
+
+func TestMain(m *testing.M) {
+	// Failed exchanges are expected in most tests, keep their logs quiet
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// echoServer Test double of the echo server. Every accepted connection
+// reads one line, writes it back and is closed. The lines received
+// are counted so tests can check what reached the server
+type echoServer struct {
+	mu       sync.Mutex
+	received map[string]int
+}
+
+// memName Returns a mem:// listener name unique to the running test
+func memName(t *testing.T, suffix string) string {
+	name := strings.NewReplacer("/", "-", "_", "-").Replace(strings.ToLower(t.Name()))
+	if suffix != "" {
+		name += "-" + suffix
+	}
+	return name
+}
+
+// listenMem Registers an in-memory listener closed when the test ends
+func listenMem(t *testing.T, name string) *MemListener {
+	t.Helper()
+	listener, err := ListenMem(name)
+	if err != nil {
+		t.Fatalf("ListenMem(%q): %v", name, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// startEchoServer Serves listener in the background until it is closed
+func startEchoServer(t *testing.T, listener net.Listener) *echoServer {
+	t.Helper()
+	server := &echoServer{received: map[string]int{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *echoServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.received[line]++
+	s.mu.Unlock()
+	if _, err := writeAll(conn, []byte(line)); err != nil {
+		return
+	}
+
+	// Wait for the client to close first. net.Pipe is unbuffered, so if
+	// both TLS ends sent close_notify at once each would block on the
+	// other until the tls.Conn close timeout
+	io.Copy(ioutil.Discard, conn)
+}
+
+// count Returns how many times line reached the server
+func (s *echoServer) count(line string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received[line]
+}
+
+// lines Returns the distinct lines that reached the server
+func (s *echoServer) lines() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := map[string]bool{}
+	for line := range s.received {
+		lines[line] = true
+	}
+	return lines
+}
+
+// startDeadServer Accepts connections on listener, reads the message
+// and closes the connection without answering, like a server that
+// dies with a message in flight. It returns the number of
+// connections accepted so far
+func startDeadServer(t *testing.T, listener net.Listener) func() int {
+	t.Helper()
+	var mu sync.Mutex
+	accepted := 0
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			accepted++
+			mu.Unlock()
+			bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+		}
+	}()
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return accepted
+	}
+}
+
+// logHook Collects the messages logged while a test runs
+type logHook struct {
+	mu       sync.Mutex
+	level    log.Level
+	prefix   string
+	messages []string
+}
+
+func (h *logHook) Levels() []log.Level { return []log.Level{h.level} }
+
+func (h *logHook) Fire(entry *log.Entry) error {
+	if strings.HasPrefix(entry.Message, h.prefix) {
+		h.mu.Lock()
+		h.messages = append(h.messages, entry.Message)
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+// logged Returns the messages collected so far
+func (h *logHook) logged() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.messages...)
+}
+
+// hookLogs Enables logging at level until the test ends and returns a
+// hook collecting the messages logged at level starting with prefix
+func hookLogs(t *testing.T, level log.Level, prefix string) *logHook {
+	hook := &logHook{level: level, prefix: prefix}
+	previous := log.GetLevel()
+	hooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	if level > previous {
+		log.SetLevel(level)
+	}
+	log.AddHook(hook)
+	t.Cleanup(func() {
+		log.SetLevel(previous)
+		log.StandardLogger().ReplaceHooks(hooks)
+	})
+	return hook
+}
+
+// startStalledServer Accepts connections on listener and reads the
+// message, but never answers nor closes the connection, like a server
+// that dropped off the network. Connections are closed when the test
+// ends
+func startStalledServer(t *testing.T, listener net.Listener) {
+	t.Helper()
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go bufio.NewReader(conn).ReadString('\n')
+		}
+	}()
+}
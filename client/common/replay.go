@@ -0,0 +1,108 @@
+package common
+
+import (
+	"bufio"
+	"io"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Replay Re-sends the client side of a capture against the server.
+// Every recorded connection is opened again, sent frames are written
+// as they were and the server responses are compared against the
+// recorded ones. The number of mismatching responses is returned
+func (c *Client) Replay(entries []CaptureEntry) (int, error) {
+	mismatches := 0
+	for _, session := range captureSessions(entries) {
+		if err := c.createClientSocket(c.dialer); err != nil {
+			return mismatches, err
+		}
+		n, err := replaySession(c.conn, session, DirectionSent)
+		c.conn.Close()
+		if err != nil {
+			log.Errorf("action: replay | result: fail | client_id: %v | conn: %v | error: %v",
+				c.config.ID,
+				session[0].Conn,
+				err,
+			)
+			return mismatches, err
+		}
+		mismatches += n
+	}
+
+	log.Infof("action: replay | result: success | client_id: %v | frames: %v | mismatches: %v",
+		c.config.ID,
+		len(entries),
+		mismatches,
+	)
+	return mismatches, nil
+}
+
+// ReplayServer Acts as a fake server playing back the server side of a
+// capture. Every accepted connection is served with the next recorded
+// session: frames the client sent are read and compared, and recorded
+// responses are written back. It returns the number of mismatching
+// client frames once every session was served
+func ReplayServer(listener net.Listener, entries []CaptureEntry) (int, error) {
+	mismatches := 0
+	for _, session := range captureSessions(entries) {
+		conn, err := listener.Accept()
+		if err != nil {
+			return mismatches, err
+		}
+		n, err := replaySession(conn, session, DirectionReceived)
+		conn.Close()
+		if err != nil {
+			log.Errorf("action: replay_server | result: fail | conn: %v | error: %v", session[0].Conn, err)
+			return mismatches, err
+		}
+		mismatches += n
+	}
+
+	log.Infof("action: replay_server | result: success | frames: %v | mismatches: %v", len(entries), mismatches)
+	return mismatches, nil
+}
+
+// replaySession Plays a recorded session over conn. Frames in the write
+// direction are written as recorded and the rest are read up to the
+// newline and compared with the recording. A frame that differs, is
+// cut short or never arrives because the peer closed the connection
+// is logged and counted as a mismatch
+func replaySession(conn net.Conn, session []CaptureEntry, write string) (int, error) {
+	reader := bufio.NewReader(conn)
+	mismatches := 0
+	closed := false
+	for _, entry := range session {
+		if entry.Direction == write {
+			if closed {
+				continue
+			}
+			if _, err := writeAll(conn, entry.Data); err != nil {
+				return mismatches, err
+			}
+			continue
+		}
+
+		var data string
+		if !closed {
+			var err error
+			data, err = reader.ReadString('\n')
+			if err == io.EOF {
+				closed = true
+			} else if err != nil {
+				return mismatches, err
+			}
+		}
+		if data != string(entry.Data) {
+			mismatches++
+			log.Warnf("action: replay_frame | result: fail | conn: %v | direction: %v | expected: %q | got: %q",
+				entry.Conn,
+				entry.Direction,
+				entry.Data,
+				data,
+			)
+		}
+	}
+	return mismatches, nil
+}
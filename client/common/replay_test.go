@@ -0,0 +1,124 @@
+package common
+
+import (
+	"bufio"
+	"testing"
+	"time"
+)
+
+// replayEntries Builds a one connection capture where every message is
+// sent and then answered by response
+func replayEntries(messages []string, response func(string) string) []CaptureEntry {
+	var entries []CaptureEntry
+	for i, msg := range messages {
+		entries = append(entries,
+			CaptureEntry{Conn: 1, MsgID: i + 1, Direction: DirectionSent, Data: []byte(msg)},
+			CaptureEntry{Conn: 1, MsgID: i + 1, Direction: DirectionReceived, Data: []byte(response(msg))},
+		)
+	}
+	return entries
+}
+
+func echo(msg string) string { return msg }
+
+// startReplayServer Runs ReplayServer in the background and returns a
+// function waiting for its result
+func startReplayServer(t *testing.T, name string, entries []CaptureEntry) func() (int, error) {
+	t.Helper()
+	listener := listenMem(t, name)
+	type result struct {
+		mismatches int
+		err        error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := ReplayServer(listener, entries)
+		done <- result{n, err}
+	}()
+	return func() (int, error) {
+		select {
+		case r := <-done:
+			return r.mismatches, r.err
+		case <-time.After(time.Second):
+			t.Fatal("ReplayServer did not return")
+			return 0, nil
+		}
+	}
+}
+
+func TestReplayServerPlaysBackCapture(t *testing.T) {
+	entries := replayEntries([]string{"first\n", "second\n"}, echo)
+	name := memName(t, "")
+	wait := startReplayServer(t, name, entries)
+
+	client := NewClient(ClientConfig{ID: "1", Dialer: &memDialer{name: name}})
+	mismatches, err := client.Replay(entries)
+	if err != nil || mismatches != 0 {
+		t.Fatalf("Replay = %v, %v, want 0 mismatches", mismatches, err)
+	}
+	if mismatches, err := wait(); err != nil || mismatches != 0 {
+		t.Fatalf("ReplayServer = %v, %v, want 0 mismatches", mismatches, err)
+	}
+}
+
+func TestReplayServerCountsDifferentFrames(t *testing.T) {
+	entries := replayEntries([]string{"a longer frame\n"}, echo)
+	tests := []struct {
+		name string
+		sent string
+	}{
+		{"shorter", "short\n"},
+		{"longer", "a much longer frame than recorded\n"},
+		{"same length", "A LONGER FRAME\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := memName(t, "")
+			wait := startReplayServer(t, name, entries)
+
+			conn, err := (&memDialer{name: name}).Dial()
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			if _, err := writeAll(conn, []byte(tt.sent)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			response, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if response != "a longer frame\n" {
+				t.Errorf("response = %q, want the recorded one", response)
+			}
+			if mismatches, err := wait(); err != nil || mismatches != 1 {
+				t.Fatalf("ReplayServer = %v, %v, want 1 mismatch", mismatches, err)
+			}
+		})
+	}
+}
+
+func TestReplayCountsShortResponses(t *testing.T) {
+	entries := replayEntries([]string{"ping\n"}, func(string) string { return "a longer response\n" })
+	name := memName(t, "")
+	startEchoServer(t, listenMem(t, name))
+
+	client := NewClient(ClientConfig{ID: "1", Dialer: &memDialer{name: name}})
+	mismatches, err := client.Replay(entries)
+	if err != nil || mismatches != 1 {
+		t.Fatalf("Replay = %v, %v, want 1 mismatch", mismatches, err)
+	}
+}
+
+func TestReplayCountsMissingResponses(t *testing.T) {
+	entries := replayEntries([]string{"first\n", "second\n"}, echo)
+	name := memName(t, "")
+	startDeadServer(t, listenMem(t, name))
+
+	client := NewClient(ClientConfig{ID: "1", Dialer: &memDialer{name: name}})
+	mismatches, err := client.Replay(entries)
+	if err != nil || mismatches != 2 {
+		t.Fatalf("Replay = %v, %v, want 2 mismatches", mismatches, err)
+	}
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig Configuration of the TLS layer used on the client connection.
+// When Mutual is set the client presents CertFile/KeyFile to the server
+// and the certificate subject must identify the agency running the client
+type TLSConfig struct {
+	Enabled    bool
+	Mutual     bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	MinVersion string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// LoadTLSConfig Builds the crypto/tls configuration used to dial the server.
+// If TLS is disabled nil is returned, meaning a plain TCP connection. In
+// mutual TLS mode the client certificate common name must be equal to the
+// agency ID, otherwise an error is returned so an agency can never
+// authenticate with a certificate issued to another one
+func LoadTLSConfig(config TLSConfig, agencyID string) (*tls.Config, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	var minVersion uint16 = tls.VersionTLS12
+	if config.MinVersion != "" {
+		version, ok := tlsVersions[config.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS min version %q", config.MinVersion)
+		}
+		minVersion = version
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: config.ServerName,
+		MinVersion: minVersion,
+	}
+
+	// Without a CA bundle the system roots are used to verify the server
+	if config.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read CA bundle %v", config.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %v", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.Mutual {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not load client certificate %v", config.CertFile)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not parse client certificate %v", config.CertFile)
+		}
+		if leaf.Subject.CommonName != agencyID {
+			return nil, fmt.Errorf(
+				"client certificate subject %q does not match agency ID %q",
+				leaf.Subject.CommonName,
+				agencyID,
+			)
+		}
+		cert.Leaf = leaf
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsDialer Dialer performing the TLS handshake over the connections
+// of another Dialer
+type tlsDialer struct {
+	dialer Dialer
+	config *tls.Config
+}
+
+// NewTLSDialer Returns a Dialer whose connections go through a TLS
+// handshake with config. If config sets no server name, the host of
+// address is used to verify the server certificate, so every server
+// of a failover list is checked against its own name
+func NewTLSDialer(dialer Dialer, config *tls.Config, address string) Dialer {
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = serverHost(address)
+	}
+	return &tlsDialer{dialer: dialer, config: config}
+}
+
+func (d *tlsDialer) Dial() (net.Conn, error) {
+	conn, err := d.dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, d.config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
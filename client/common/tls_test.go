@@ -0,0 +1,127 @@
+package common
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/tlstest"
+)
+
+// startTLSEchoServer Starts an echo server over a mem:// listener
+// speaking TLS with a certificate for localhost. It returns the server
+// address and the CA that issued its certificate
+func startTLSEchoServer(t *testing.T, mutual bool) (string, *tlstest.CA) {
+	t.Helper()
+	ca := mustCA(t)
+	serverConfig, err := ca.ServerConfig(mutual, "localhost")
+	if err != nil {
+		t.Fatalf("ServerConfig: %v", err)
+	}
+
+	name := memName(t, "")
+	startEchoServer(t, tls.NewListener(listenMem(t, name), serverConfig))
+	return "mem://" + name, ca
+}
+
+func exchangeOverTLS(t *testing.T, address string, config TLSConfig) {
+	t.Helper()
+	tlsConfig, err := LoadTLSConfig(config, "1")
+	if err != nil {
+		t.Fatalf("LoadTLSConfig: %v", err)
+	}
+	dialer, err := NewDialer(address, 0)
+	if err != nil {
+		t.Fatalf("NewDialer: %v", err)
+	}
+
+	client := NewClient(ClientConfig{ID: "1", Dialer: NewTLSDialer(dialer, tlsConfig, address)})
+	msg := "[CLIENT 1] Message N°1\n"
+	response, err := client.exchangeMessage(1, msg)
+	if err != nil {
+		t.Fatalf("exchangeMessage: %v", err)
+	}
+	if response != msg {
+		t.Fatalf("response = %q, want %q", response, msg)
+	}
+}
+
+func TestLoadTLSConfigDisabledReturnsNil(t *testing.T) {
+	config, err := LoadTLSConfig(TLSConfig{}, "1")
+	if err != nil || config != nil {
+		t.Fatalf("LoadTLSConfig = %v, %v, want nil, nil", config, err)
+	}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	address, ca := startTLSEchoServer(t, false)
+	caFile, _, _, err := ca.WriteFiles(t.TempDir(), "1")
+	if err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+
+	exchangeOverTLS(t, address, TLSConfig{
+		Enabled:    true,
+		CAFile:     caFile,
+		ServerName: "localhost",
+		MinVersion: "1.3",
+	})
+}
+
+func TestMutualTLSHandshake(t *testing.T) {
+	address, ca := startTLSEchoServer(t, true)
+	caFile, certFile, keyFile, err := ca.WriteFiles(t.TempDir(), "1")
+	if err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+
+	exchangeOverTLS(t, address, TLSConfig{
+		Enabled:    true,
+		Mutual:     true,
+		CAFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "localhost",
+	})
+}
+
+func TestLoadTLSConfigRejectsCertificateOfAnotherAgency(t *testing.T) {
+	caFile, certFile, keyFile, err := mustCA(t).WriteFiles(t.TempDir(), "2")
+	if err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+
+	_, err = LoadTLSConfig(TLSConfig{
+		Enabled:  true,
+		Mutual:   true,
+		CAFile:   caFile,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}, "1")
+	if err == nil || !strings.Contains(err.Error(), "does not match agency ID") {
+		t.Fatalf("LoadTLSConfig error = %v, want agency mismatch", err)
+	}
+}
+
+func TestLoadTLSConfigRejectsUnknownMinVersion(t *testing.T) {
+	_, err := LoadTLSConfig(TLSConfig{Enabled: true, MinVersion: "1.4"}, "1")
+	if err == nil || !strings.Contains(err.Error(), "unsupported TLS min version") {
+		t.Fatalf("LoadTLSConfig error = %v, want unsupported min version", err)
+	}
+}
+
+func TestLoadTLSConfigRejectsMissingCABundle(t *testing.T) {
+	_, err := LoadTLSConfig(TLSConfig{Enabled: true, CAFile: t.TempDir() + "/missing.pem"}, "1")
+	if err == nil {
+		t.Fatal("LoadTLSConfig with a missing CA bundle succeeded")
+	}
+}
+
+func mustCA(t *testing.T) *tlstest.CA {
+	t.Helper()
+	ca, err := tlstest.NewCA()
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	return ca
+}
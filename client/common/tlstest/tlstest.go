@@ -0,0 +1,150 @@
+// Package tlstest generates a throwaway certificate authority and
+// certificates so the TLS and mutual TLS handshake can be exercised
+// locally without external tooling such as openssl
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// certificateLifetime Validity of every generated certificate. Certificates
+// are only meant to live for the duration of a test run
+const certificateLifetime = time.Hour
+
+// CA Certificate authority created at test time
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+// NewCA Creates a new self signed certificate authority
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tlstest CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(certificateLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{
+		Cert:    cert,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:     key,
+	}, nil
+}
+
+// Issue Creates a certificate signed by the CA. commonName is used as
+// the certificate subject (the agency ID for client certificates) and
+// hosts, which may be DNS names or IPs, are added as SANs so the
+// certificate can also be used by a server
+func (ca *CA) Issue(commonName string, hosts ...string) (tls.Certificate, []byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return tls.Certificate{}, nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(certificateLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	return cert, certPEM, keyPEM, err
+}
+
+// WriteFiles Writes the CA bundle and a certificate issued for commonName
+// into dir, returning the paths in the same shape the client expects
+// them in its tls configuration
+func (ca *CA) WriteFiles(dir string, commonName string, hosts ...string) (caFile, certFile, keyFile string, err error) {
+	_, certPEM, keyPEM, err := ca.Issue(commonName, hosts...)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	caFile = filepath.Join(dir, "ca.pem")
+	certFile = filepath.Join(dir, commonName+".pem")
+	keyFile = filepath.Join(dir, commonName+"-key.pem")
+	if err = ioutil.WriteFile(caFile, ca.CertPEM, 0600); err != nil {
+		return "", "", "", err
+	}
+	if err = ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		return "", "", "", err
+	}
+	if err = ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return "", "", "", err
+	}
+	return caFile, certFile, keyFile, nil
+}
+
+// ServerConfig Returns a server side TLS configuration presenting a
+// certificate for hosts. If mutual is set, client certificates signed by
+// the CA are required
+func (ca *CA) ServerConfig(mutual bool, hosts ...string) (*tls.Config, error) {
+	cert, _, _, err := ca.Issue("tlstest server", hosts...)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if mutual {
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.Cert)
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
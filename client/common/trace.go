@@ -0,0 +1,52 @@
+package common
+
+import (
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// traceFrame Logs a frame exchanged with the server when the log level
+// is trace. The frame is logged decoded and, if HexDumpLength is
+// positive, followed by a hex dump of its raw bytes with offsets,
+// truncated to HexDumpLength bytes. A frame cut short by err is logged
+// as failed with the bytes that were transferred
+func (c *Client) traceFrame(msgID int, direction string, data []byte, err error) {
+	if !log.IsLevelEnabled(log.TraceLevel) {
+		return
+	}
+
+	if err != nil {
+		log.Tracef("action: frame | result: fail | direction: %v | client_id: %v | msg_id: %v | length: %v | msg: %q | error: %v",
+			direction,
+			c.config.ID,
+			msgID,
+			len(data),
+			data,
+			err,
+		)
+	} else {
+		log.Tracef("action: frame | result: success | direction: %v | client_id: %v | msg_id: %v | length: %v | msg: %q",
+			direction,
+			c.config.ID,
+			msgID,
+			len(data),
+			data,
+		)
+	}
+
+	if c.config.HexDumpLength <= 0 {
+		return
+	}
+	dump := data
+	if len(dump) > c.config.HexDumpLength {
+		dump = dump[:c.config.HexDumpLength]
+	}
+	log.Tracef("action: frame_dump | direction: %v | client_id: %v | msg_id: %v | truncated: %v\n%s",
+		direction,
+		c.config.ID,
+		msgID,
+		len(dump) < len(data),
+		hex.Dump(dump),
+	)
+}
@@ -0,0 +1,206 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dialer Opens connections to the server over a specific transport
+type Dialer interface {
+	Dial() (net.Conn, error)
+}
+
+// NewDialer Returns the Dialer for the given server address, selected by
+// its URL scheme:
+//
+//	tcp://host:port       TCP connection
+//	unix:///path/to/sock  Unix domain socket
+//	mem://name            in-memory pipe to a listener created by ListenMem
+//
+// An address without scheme (host:port) is treated as TCP. Network
+// connections give up after timeout, zero meaning no timeout
+func NewDialer(address string, timeout time.Duration) (Dialer, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "mem" {
+		return &memDialer{name: addr}, nil
+	}
+	return &netDialer{network: network, address: addr, timeout: timeout}, nil
+}
+
+// Listen Opens a listener on the given address. It accepts the same
+// schemes as NewDialer, so a fake server can be reached by the client
+// through any transport
+func Listen(address string) (net.Listener, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "mem" {
+		return ListenMem(addr)
+	}
+	return net.Listen(network, addr)
+}
+
+// parseAddress Splits a server address into the transport network and
+// the address within that transport
+func parseAddress(address string) (string, string, error) {
+	if !strings.Contains(address, "://") {
+		return "tcp", address, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid server address %q: %v", address, err)
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		return "tcp", u.Host, nil
+	case "unix", "mem":
+		return u.Scheme, u.Host + u.Path, nil
+	default:
+		return "", "", fmt.Errorf("unsupported transport %q in server address %q", u.Scheme, address)
+	}
+}
+
+// serverHost Returns the host name of a server address, used as the
+// default TLS server name. For mem:// addresses the listener name is
+// the host. Unix sockets have no host and an empty string is returned
+func serverHost(address string) string {
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return ""
+		}
+		switch u.Scheme {
+		case "mem":
+			return u.Host
+		case "tcp":
+			address = u.Host
+		default:
+			return ""
+		}
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// netDialer Dialer for the transports supported by the net package
+type netDialer struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func (d *netDialer) Dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(d.network, d.address, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	// Bound a handshake done on top of the connection too. The client
+	// sets its own deadline once the connection is established
+	if d.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.timeout))
+	}
+	return conn, nil
+}
+
+// memListeners In-memory listeners registered by name
+var memListeners = struct {
+	sync.Mutex
+	byName map[string]*MemListener
+}{byName: map[string]*MemListener{}}
+
+// MemListener net.Listener whose connections are in-memory pipes created
+// by dialing mem://name. It lets the client run against a server in the
+// same process without opening real ports
+type MemListener struct {
+	name  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// ListenMem Registers a new in-memory listener under name. An error is
+// returned if the name is already in use
+func ListenMem(name string) (*MemListener, error) {
+	memListeners.Lock()
+	defer memListeners.Unlock()
+
+	if _, ok := memListeners.byName[name]; ok {
+		return nil, fmt.Errorf("mem listener %q already exists", name)
+	}
+	l := &MemListener{
+		name:  name,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	memListeners.byName[name] = l
+	return l, nil
+}
+
+// Accept Waits for and returns the next in-memory connection
+func (l *MemListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close Unregisters the listener. Pending and future dials fail
+func (l *MemListener) Close() error {
+	l.once.Do(func() {
+		memListeners.Lock()
+		delete(memListeners.byName, l.name)
+		memListeners.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr Returns the listener address
+func (l *MemListener) Addr() net.Addr {
+	return memAddr(l.name)
+}
+
+// memAddr net.Addr of in-memory connections
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return "mem://" + string(a) }
+
+// memDialer Dialer for in-memory listeners
+type memDialer struct {
+	name string
+}
+
+func (d *memDialer) Dial() (net.Conn, error) {
+	memListeners.Lock()
+	l, ok := memListeners.byName[d.name]
+	memListeners.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dial mem://%v: no such listener", d.name)
+	}
+
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("dial mem://%v: listener closed", d.name)
+	}
+}
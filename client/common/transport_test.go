@@ -0,0 +1,180 @@
+package common
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		addr    string
+	}{
+		{"server:12345", "tcp", "server:12345"},
+		{"tcp://server:12345", "tcp", "server:12345"},
+		{"unix:///var/run/server.sock", "unix", "/var/run/server.sock"},
+		{"unix://server.sock", "unix", "server.sock"},
+		{"mem://server", "mem", "server"},
+	}
+
+	for _, test := range tests {
+		network, addr, err := parseAddress(test.address)
+		if err != nil {
+			t.Errorf("parseAddress(%q): %v", test.address, err)
+			continue
+		}
+		if network != test.network || addr != test.addr {
+			t.Errorf("parseAddress(%q) = %q, %q, want %q, %q", test.address, network, addr, test.network, test.addr)
+		}
+	}
+}
+
+func TestNewDialerRejectsUnknownScheme(t *testing.T) {
+	for _, address := range []string{"udp://server:12345", "http://server"} {
+		if _, err := NewDialer(address, 0); err == nil {
+			t.Errorf("NewDialer(%q) succeeded, want error", address)
+		}
+	}
+}
+
+func TestNewDialerSelectsTransport(t *testing.T) {
+	tests := []struct {
+		address string
+		dialer  Dialer
+	}{
+		{"server:12345", &netDialer{network: "tcp", address: "server:12345", timeout: time.Second}},
+		{"tcp://server:12345", &netDialer{network: "tcp", address: "server:12345", timeout: time.Second}},
+		{"unix:///tmp/server.sock", &netDialer{network: "unix", address: "/tmp/server.sock", timeout: time.Second}},
+		{"mem://server", &memDialer{name: "server"}},
+	}
+
+	for _, test := range tests {
+		dialer, err := NewDialer(test.address, time.Second)
+		if err != nil {
+			t.Errorf("NewDialer(%q): %v", test.address, err)
+			continue
+		}
+		switch want := test.dialer.(type) {
+		case *netDialer:
+			got, ok := dialer.(*netDialer)
+			if !ok || *got != *want {
+				t.Errorf("NewDialer(%q) = %#v, want %#v", test.address, dialer, want)
+			}
+		case *memDialer:
+			got, ok := dialer.(*memDialer)
+			if !ok || *got != *want {
+				t.Errorf("NewDialer(%q) = %#v, want %#v", test.address, dialer, want)
+			}
+		}
+	}
+}
+
+func TestServerHost(t *testing.T) {
+	tests := map[string]string{
+		"server:12345":            "server",
+		"tcp://server:12345":      "server",
+		"unix:///tmp/server.sock": "",
+		"mem://server":            "server",
+		"server":                  "",
+	}
+	for address, want := range tests {
+		if got := serverHost(address); got != want {
+			t.Errorf("serverHost(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
+
+func exchangeOver(t *testing.T, address string) {
+	t.Helper()
+	dialer, err := NewDialer(address, 0)
+	if err != nil {
+		t.Fatalf("NewDialer(%q): %v", address, err)
+	}
+
+	client := NewClient(ClientConfig{ID: "1", Dialer: dialer})
+	msg := "[CLIENT 1] Message N°1\n"
+	response, err := client.exchangeMessage(1, msg)
+	if err != nil {
+		t.Fatalf("exchangeMessage over %v: %v", address, err)
+	}
+	if response != msg {
+		t.Fatalf("response over %v = %q, want %q", address, response, msg)
+	}
+}
+
+func TestExchangeOverMem(t *testing.T) {
+	address := "mem://" + memName(t, "")
+	listener, err := Listen(address)
+	if err != nil {
+		t.Fatalf("Listen(%q): %v", address, err)
+	}
+	defer listener.Close()
+	startEchoServer(t, listener)
+
+	exchangeOver(t, address)
+}
+
+func TestExchangeOverUnixSocket(t *testing.T) {
+	address := "unix://" + filepath.Join(t.TempDir(), "server.sock")
+	listener, err := Listen(address)
+	if err != nil {
+		t.Fatalf("Listen(%q): %v", address, err)
+	}
+	defer listener.Close()
+	startEchoServer(t, listener)
+
+	exchangeOver(t, address)
+}
+
+func TestListenMemRejectsDuplicateName(t *testing.T) {
+	name := memName(t, "")
+	listenMem(t, name)
+	if _, err := ListenMem(name); err == nil {
+		t.Fatalf("second ListenMem(%q) succeeded, want error", name)
+	}
+}
+
+func TestListenMemNameReusableAfterClose(t *testing.T) {
+	name := memName(t, "")
+	listener, err := ListenMem(name)
+	if err != nil {
+		t.Fatalf("ListenMem(%q): %v", name, err)
+	}
+	listener.Close()
+	listenMem(t, name)
+}
+
+func TestMemDialAfterCloseFails(t *testing.T) {
+	name := memName(t, "")
+	listener, err := ListenMem(name)
+	if err != nil {
+		t.Fatalf("ListenMem(%q): %v", name, err)
+	}
+	listener.Close()
+
+	if _, err := (&memDialer{name: name}).Dial(); err == nil {
+		t.Fatal("Dial after Close succeeded, want error")
+	}
+	if _, err := listener.Accept(); err != net.ErrClosed {
+		t.Fatalf("Accept after Close error = %v, want net.ErrClosed", err)
+	}
+	// Closing twice must not panic
+	listener.Close()
+}
+
+func TestMemDialUnknownListenerFails(t *testing.T) {
+	if _, err := (&memDialer{name: memName(t, "")}).Dial(); err == nil {
+		t.Fatal("Dial without listener succeeded, want error")
+	}
+}
+
+func TestMemListenerAddr(t *testing.T) {
+	name := memName(t, "")
+	listener := listenMem(t, name)
+	if got := listener.Addr().String(); got != "mem://"+name {
+		t.Fatalf("Addr() = %q, want %q", got, "mem://"+name)
+	}
+}
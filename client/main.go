@@ -1,7 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,9 +36,34 @@ func InitConfig() (*viper.Viper, error) {
 	// Add env variables supported
 	v.BindEnv("id")
 	v.BindEnv("server", "address")
+	v.BindEnv("server", "policy")
+	v.BindEnv("server", "cooldown")
+	v.BindEnv("server", "timeout")
 	v.BindEnv("loop", "period")
 	v.BindEnv("loop", "lapse")
 	v.BindEnv("log", "level")
+	v.BindEnv("log", "hexDumpLength")
+	v.BindEnv("tls", "enabled")
+	v.BindEnv("tls", "mutual")
+	v.BindEnv("tls", "ca")
+	v.BindEnv("tls", "cert")
+	v.BindEnv("tls", "key")
+	v.BindEnv("tls", "serverName")
+	v.BindEnv("tls", "minVersion")
+	v.BindEnv("faults", "enabled")
+	v.BindEnv("faults", "shortWrites")
+	v.BindEnv("faults", "maxReadSize")
+	v.BindEnv("faults", "maxDelay")
+	v.BindEnv("faults", "dropAfterBytes")
+	v.BindEnv("faults", "garbleRate")
+	v.BindEnv("faults", "seed")
+	v.BindEnv("capture", "file")
+	v.BindEnv("breaker", "enabled")
+	v.BindEnv("breaker", "consecutiveFailures")
+	v.BindEnv("breaker", "failureRate")
+	v.BindEnv("breaker", "minRequests")
+	v.BindEnv("breaker", "window")
+	v.BindEnv("breaker", "openTimeout")
 
 	// Try to read configuration from config file. If config file
 	// does not exists then ReadInConfig will fail but configuration
@@ -54,9 +83,58 @@ func InitConfig() (*viper.Viper, error) {
 		return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_PERIOD env var as time.Duration.")
 	}
 
+	if v.IsSet("server.cooldown") {
+		if _, err := time.ParseDuration(v.GetString("server.cooldown")); err != nil {
+			return nil, errors.Wrapf(err, "Could not parse CLI_SERVER_COOLDOWN env var as time.Duration.")
+		}
+	}
+
+	if v.IsSet("server.timeout") {
+		if _, err := time.ParseDuration(v.GetString("server.timeout")); err != nil {
+			return nil, errors.Wrapf(err, "Could not parse CLI_SERVER_TIMEOUT env var as time.Duration.")
+		}
+	}
+
+	if v.IsSet("breaker.window") {
+		if _, err := time.ParseDuration(v.GetString("breaker.window")); err != nil {
+			return nil, errors.Wrapf(err, "Could not parse CLI_BREAKER_WINDOW env var as time.Duration.")
+		}
+	}
+
+	if v.IsSet("breaker.openTimeout") {
+		if _, err := time.ParseDuration(v.GetString("breaker.openTimeout")); err != nil {
+			return nil, errors.Wrapf(err, "Could not parse CLI_BREAKER_OPENTIMEOUT env var as time.Duration.")
+		}
+	}
+
+	if v.IsSet("breaker.failureRate") {
+		rate, err := strconv.ParseFloat(v.GetString("breaker.failureRate"), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not parse CLI_BREAKER_FAILURERATE env var as float.")
+		}
+		if rate < 0 || rate > 1 {
+			return nil, errors.Errorf("CLI_BREAKER_FAILURERATE must be between 0 and 1, got %v.", rate)
+		}
+	}
+
 	return v, nil
 }
 
+// ServerAddresses Returns the server addresses configured in
+// server.address. It accepts a single address, a YAML list or a
+// comma separated list of addresses
+func ServerAddresses(v *viper.Viper) []string {
+	var addresses []string
+	for _, entry := range v.GetStringSlice("server.address") {
+		for _, address := range strings.Split(entry, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
 // InitLogger Receives the log level to be set in logrus as a string. This method
 // parses the string and set the level to the logger. If the level string is not
 // valid an error is returned
@@ -78,16 +156,126 @@ func InitLogger(logLevel string) error {
 // PrintConfig Print all the configuration parameters of the program.
 // For debugging purposes only
 func PrintConfig(v *viper.Viper) {
-	logrus.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_lapse: %v | loop_period: %v | log_level: %s",
+	logrus.Infof("action: config | result: success | client_id: %s | server_address: %s | server_policy: %s | server_timeout: %v | loop_lapse: %v | loop_period: %v | log_level: %s | tls_enabled: %v | tls_mutual: %v",
 	    v.GetString("id"),
-	    v.GetString("server.address"),
+	    strings.Join(ServerAddresses(v), ","),
+	    v.GetString("server.policy"),
+	    v.GetDuration("server.timeout"),
 	    v.GetDuration("loop.lapse"),
 	    v.GetDuration("loop.period"),
 	    v.GetString("log.level"),
+	    v.GetBool("tls.enabled"),
+	    v.GetBool("tls.mutual"),
     )
 }
 
+// InitClientConfig Builds the client configuration from the parsed
+// parameters, without the transport. InitTransport must be called
+// before connecting to the server
+func InitClientConfig(v *viper.Viper) common.ClientConfig {
+	var serverAddress string
+	if addresses := ServerAddresses(v); len(addresses) > 0 {
+		serverAddress = addresses[0]
+	}
+
+	return common.ClientConfig{
+		ServerAddress: serverAddress,
+		ID:            v.GetString("id"),
+		LoopLapse:     v.GetDuration("loop.lapse"),
+		LoopPeriod:    v.GetDuration("loop.period"),
+		Timeout:       v.GetDuration("server.timeout"),
+		HexDumpLength: v.GetInt("log.hexDumpLength"),
+	}
+}
+
+// InitTransport Completes config with the transport selected by
+// server.address, loading the TLS material, fault injection, failover
+// and the circuit breaker. Any failure is fatal
+func InitTransport(v *viper.Viper, config *common.ClientConfig) {
+	tlsConfig, err := common.LoadTLSConfig(common.TLSConfig{
+		Enabled:    v.GetBool("tls.enabled"),
+		Mutual:     v.GetBool("tls.mutual"),
+		CAFile:     v.GetString("tls.ca"),
+		CertFile:   v.GetString("tls.cert"),
+		KeyFile:    v.GetString("tls.key"),
+		ServerName: v.GetString("tls.serverName"),
+		MinVersion: v.GetString("tls.minVersion"),
+	}, v.GetString("id"))
+	if err != nil {
+		log.Fatalf("action: load_tls_config | result: fail | client_id: %v | error: %v", v.GetString("id"), err)
+	}
+
+	addresses := ServerAddresses(v)
+	if len(addresses) == 0 {
+		log.Fatalf("action: config | result: fail | client_id: %v | error: no server address configured", v.GetString("id"))
+	}
+
+	// Fault injection is only meant for resilience testing. Faults are
+	// injected per server so failover sees them as server failures
+	var faults *common.FaultConfig
+	if v.GetBool("faults.enabled") {
+		faults = &common.FaultConfig{
+			ShortWrites:    v.GetBool("faults.shortWrites"),
+			MaxReadSize:    v.GetInt("faults.maxReadSize"),
+			MaxDelay:       v.GetDuration("faults.maxDelay"),
+			DropAfterBytes: v.GetInt("faults.dropAfterBytes"),
+			GarbleRate:     v.GetFloat64("faults.garbleRate"),
+			Seed:           v.GetInt64("faults.seed"),
+		}
+		log.Warnf("action: fault_injection | result: enabled | client_id: %v | config: %+v", v.GetString("id"), *faults)
+	}
+
+	var endpoints []common.Endpoint
+	for _, address := range addresses {
+		dialer, err := common.NewDialer(address, config.Timeout)
+		if err != nil {
+			log.Fatalf("action: config | result: fail | client_id: %v | error: %v", v.GetString("id"), err)
+		}
+		if faults != nil {
+			dialer = common.NewFaultDialer(dialer, *faults)
+		}
+		// The handshake is done per server, so each one is verified
+		// against its own host name
+		if tlsConfig != nil {
+			dialer = common.NewTLSDialer(dialer, tlsConfig, address)
+		}
+		endpoints = append(endpoints, common.Endpoint{Address: address, Dialer: dialer})
+	}
+
+	dialer := endpoints[0].Dialer
+	if len(endpoints) > 1 {
+		policy := v.GetString("server.policy")
+		if policy == "" {
+			policy = common.PolicyOrdered
+		}
+		failover, err := common.NewFailoverDialer(endpoints, policy, v.GetDuration("server.cooldown"))
+		if err != nil {
+			log.Fatalf("action: config | result: fail | client_id: %v | error: %v", v.GetString("id"), err)
+		}
+		dialer = failover
+	}
+
+	var breaker *common.CircuitBreaker
+	if v.GetBool("breaker.enabled") {
+		breaker = common.NewCircuitBreaker(v.GetString("id"), common.BreakerConfig{
+			ConsecutiveFailures: v.GetInt("breaker.consecutiveFailures"),
+			FailureRate:         v.GetFloat64("breaker.failureRate"),
+			MinRequests:         v.GetInt("breaker.minRequests"),
+			Window:              v.GetDuration("breaker.window"),
+			OpenTimeout:         v.GetDuration("breaker.openTimeout"),
+		})
+	}
+
+	config.Dialer = dialer
+	config.SendAttempts = len(endpoints)
+	config.Breaker = breaker
+}
+
 func main() {
+	dryRun := flag.Bool("dry-run", false, "write frames to --dry-run-output instead of connecting to the server")
+	dryRunOutput := flag.String("dry-run-output", "-", "file where frames are written in dry run mode, - for stdout")
+	flag.Parse()
+
 	v, err := InitConfig()
 	if err != nil {
 		log.Fatalf("%s", err)
@@ -100,13 +288,53 @@ func main() {
 	// Print program config with debugging purposes
 	PrintConfig(v)
 
-	clientConfig := common.ClientConfig{
-		ServerAddress: v.GetString("server.address"),
-		ID:            v.GetString("id"),
-		LoopLapse:     v.GetDuration("loop.lapse"),
-		LoopPeriod:    v.GetDuration("loop.period"),
+	clientConfig := InitClientConfig(v)
+
+	// Subcommands. Without them the client runs its regular loop
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "replay":
+			InitTransport(v, &clientConfig)
+			RunReplay(clientConfig, flag.Args()[1:])
+			return
+		default:
+			log.Fatalf("action: parse_command | result: fail | client_id: %v | error: unknown command %q", clientConfig.ID, flag.Arg(0))
+		}
+	}
+
+	// In dry run mode frames never reach the network, so the transport
+	// is never loaded: TLS material, fault injection and failover do
+	// not apply and a dry run works without certificates
+	var dryRunDialer *common.DryRun
+	if !*dryRun {
+		InitTransport(v, &clientConfig)
+	} else {
+		var out io.Writer = os.Stdout
+		if *dryRunOutput != "-" {
+			file, err := os.Create(*dryRunOutput)
+			if err != nil {
+				log.Fatalf("action: dry_run | result: fail | client_id: %v | error: %v", clientConfig.ID, err)
+			}
+			defer file.Close()
+			out = file
+		}
+		dryRunDialer = common.NewDryRun(out)
+		clientConfig.Dialer = dryRunDialer
+	}
+
+	if captureFile := v.GetString("capture.file"); captureFile != "" {
+		recorder, err := common.NewRecorder(captureFile)
+		if err != nil {
+			log.Fatalf("action: capture | result: fail | client_id: %v | error: %v", clientConfig.ID, err)
+		}
+		defer recorder.Close()
+		clientConfig.Recorder = recorder
 	}
 
 	client := common.NewClient(clientConfig)
 	client.StartClientLoop()
+
+	if dryRunDialer != nil {
+		dryRunDialer.Report(clientConfig.ID)
+	}
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+const replayUsage = "usage: client replay client|server <capture-file>"
+
+// RunReplay Runs the replay command over a capture file recorded with
+// capture.file. In client mode the recorded client frames are re-sent
+// to server.address. In server mode the client listens on server.address
+// and acts as a fake server playing back the recorded responses
+func RunReplay(config common.ClientConfig, args []string) {
+	if len(args) != 2 {
+		log.Fatalf("action: replay | result: fail | client_id: %v | error: %v", config.ID, replayUsage)
+	}
+
+	entries, err := common.LoadCapture(args[1])
+	if err != nil {
+		log.Fatalf("action: replay | result: fail | client_id: %v | error: %v", config.ID, err)
+	}
+
+	switch args[0] {
+	case "client":
+		client := common.NewClient(config)
+		if _, err := client.Replay(entries); err != nil {
+			log.Fatalf("action: replay | result: fail | client_id: %v | error: %v", config.ID, err)
+		}
+	case "server":
+		listener, err := common.Listen(config.ServerAddress)
+		if err != nil {
+			log.Fatalf("action: replay_server | result: fail | client_id: %v | error: %v", config.ID, err)
+		}
+		defer listener.Close()
+		if _, err := common.ReplayServer(listener, entries); err != nil {
+			log.Fatalf("action: replay_server | result: fail | client_id: %v | error: %v", config.ID, err)
+		}
+	default:
+		log.Fatalf("action: replay | result: fail | client_id: %v | error: %v", config.ID, replayUsage)
+	}
+}